@@ -0,0 +1,176 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RebootRequiredAnnotation is set on a node to request a reboot, following
+// the same convention as kured.
+const RebootRequiredAnnotation = "weave.works/kured-reboot-required"
+
+// A NodeAction runs against a node once it has been successfully drained.
+// Implementations might reboot the node, delete it, or notify an external
+// system.
+type NodeAction interface {
+	Execute(n *core.Node) error
+}
+
+// A TimedNodeAction is a NodeAction that wants to know when the drain that
+// preceded it started and finished, e.g. to report that window to an
+// external system. If a configured NodeAction implements this interface,
+// the caller calls SetDrainWindow before every Execute.
+type TimedNodeAction interface {
+	NodeAction
+
+	// SetDrainWindow records when the most recent drain started and
+	// completed, for use by the next call to Execute.
+	SetDrainWindow(started, completed time.Time)
+}
+
+// RebootAction requests a reboot of a successfully drained node by
+// annotating it, following the kured reboot-required convention. It does not
+// itself reboot the node; a reboot daemon such as kured is expected to act
+// on the annotation.
+type RebootAction struct {
+	c kubernetes.Interface
+}
+
+// NewRebootAction returns a NodeAction that annotates drained nodes as
+// requiring a reboot.
+func NewRebootAction(c kubernetes.Interface) *RebootAction {
+	return &RebootAction{c: c}
+}
+
+// Execute annotates n to request a reboot.
+func (a *RebootAction) Execute(n *core.Node) error {
+	fresh, err := a.c.CoreV1().Nodes().Get(n.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get node %s: %v", n.GetName(), err)
+	}
+	if fresh.GetAnnotations()[RebootRequiredAnnotation] == "true" {
+		return nil
+	}
+	if fresh.Annotations == nil {
+		fresh.Annotations = make(map[string]string)
+	}
+	fresh.Annotations[RebootRequiredAnnotation] = "true"
+	if _, err := a.c.CoreV1().Nodes().Update(fresh); err != nil {
+		return fmt.Errorf("cannot annotate node %s for reboot: %v", n.GetName(), err)
+	}
+	return nil
+}
+
+// DeleteNodeAction deletes a successfully drained Node object, so that the
+// cloud controller manager (or equivalent) reconciles away the now-empty
+// underlying instance.
+type DeleteNodeAction struct {
+	c kubernetes.Interface
+}
+
+// NewDeleteNodeAction returns a NodeAction that deletes drained nodes.
+func NewDeleteNodeAction(c kubernetes.Interface) *DeleteNodeAction {
+	return &DeleteNodeAction{c: c}
+}
+
+// Execute deletes n.
+func (a *DeleteNodeAction) Execute(n *core.Node) error {
+	if err := a.c.CoreV1().Nodes().Delete(n.GetName(), &metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("cannot delete node %s: %v", n.GetName(), err)
+	}
+	return nil
+}
+
+// webhookPayload is the body POSTed by a WebhookAction.
+type webhookPayload struct {
+	Node             string              `json:"node"`
+	Conditions       []core.NodeCondition `json:"conditions"`
+	DrainStartedAt   time.Time           `json:"drainStartedAt"`
+	DrainCompletedAt time.Time           `json:"drainCompletedAt"`
+}
+
+// WebhookAction POSTs a JSON payload describing the drained node to an
+// external URL, retrying on failure. It implements TimedNodeAction so its
+// caller can tell it when the drain that preceded it ran.
+type WebhookAction struct {
+	url        string
+	client     *http.Client
+	retries    int
+	retryDelay time.Duration
+
+	drainStartedAt   time.Time
+	drainCompletedAt time.Time
+}
+
+// NewWebhookAction returns a NodeAction that POSTs to the supplied URL.
+func NewWebhookAction(url string) *WebhookAction {
+	return &WebhookAction{
+		url:        url,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retries:    3,
+		retryDelay: time.Second,
+	}
+}
+
+// SetDrainWindow records when the drain that's about to be followed by this
+// action started and completed, for inclusion in the next webhook payload.
+func (a *WebhookAction) SetDrainWindow(started, completed time.Time) {
+	a.drainStartedAt = started
+	a.drainCompletedAt = completed
+}
+
+// Execute POSTs a payload describing n to this action's URL, retrying a
+// handful of times on failure before giving up.
+func (a *WebhookAction) Execute(n *core.Node) error {
+	p := webhookPayload{
+		Node:             n.GetName(),
+		Conditions:       n.Status.Conditions,
+		DrainStartedAt:   a.drainStartedAt,
+		DrainCompletedAt: a.drainCompletedAt,
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("cannot marshal webhook payload for node %s: %v", n.GetName(), err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= a.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(a.retryDelay)
+		}
+		resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("cannot notify webhook for node %s: %v", n.GetName(), lastErr)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRebootActionExecute(t *testing.T) {
+	node := &core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	client := fake.NewSimpleClientset(node)
+	a := NewRebootAction(client)
+
+	if err := a.Execute(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fresh, err := client.CoreV1().Nodes().Get("node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fresh.GetAnnotations()[RebootRequiredAnnotation] != "true" {
+		t.Error("expected node to be annotated as requiring a reboot")
+	}
+
+	// Calling Execute again on an already-annotated node should be a no-op,
+	// not an error.
+	if err := a.Execute(fresh); err != nil {
+		t.Fatalf("unexpected error on second Execute: %v", err)
+	}
+}
+
+func TestDeleteNodeActionExecute(t *testing.T) {
+	node := &core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	client := fake.NewSimpleClientset(node)
+	a := NewDeleteNodeAction(client)
+
+	if err := a.Execute(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.CoreV1().Nodes().Get("node-a", metav1.GetOptions{}); err == nil {
+		t.Error("expected node to have been deleted")
+	}
+}
+
+func TestWebhookActionExecute(t *testing.T) {
+	started := time.Unix(100, 0).UTC()
+	completed := time.Unix(200, 0).UTC()
+
+	var got webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("cannot decode webhook payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	a := NewWebhookAction(srv.URL)
+	a.SetDrainWindow(started, completed)
+
+	node := &core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	if err := a.Execute(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Node != "node-a" {
+		t.Errorf("payload.Node = %q, want %q", got.Node, "node-a")
+	}
+	if !got.DrainStartedAt.Equal(started) {
+		t.Errorf("payload.DrainStartedAt = %v, want %v", got.DrainStartedAt, started)
+	}
+	if !got.DrainCompletedAt.Equal(completed) {
+		t.Errorf("payload.DrainCompletedAt = %v, want %v", got.DrainCompletedAt, completed)
+	}
+}
+
+func TestWebhookActionImplementsTimedNodeAction(t *testing.T) {
+	var _ TimedNodeAction = NewWebhookAction("http://example.invalid")
+}
+
+func TestWebhookActionRetriesOnFailure(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	a := NewWebhookAction(srv.URL)
+	a.retryDelay = time.Millisecond
+
+	if err := a.Execute(&core.Node{}); err == nil {
+		t.Fatal("expected an error when the webhook always fails")
+	}
+	if attempts != a.retries+1 {
+		t.Errorf("made %d attempts, want %d", attempts, a.retries+1)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// drainCacheConfigMapPrefix is prepended to the node UID to produce the
+	// name of the ConfigMap used to persist that node's drain record.
+	drainCacheConfigMapPrefix = "draino-drain-"
+	drainCacheDataKey         = "record"
+
+	// drainCacheLabelManagedBy marks a ConfigMap as owned by this cache so it
+	// can be safely listed and garbage collected.
+	drainCacheLabelManagedBy = "app.kubernetes.io/managed-by"
+	drainCacheLabelValue     = "draino"
+)
+
+// A NodeRecord captures everything a Cache needs to remember about a single
+// node's drain lifecycle in order to survive a controller restart.
+type NodeRecord struct {
+	// CordonedAt is when the node was cordoned.
+	CordonedAt time.Time `json:"cordonedAt,omitempty"`
+	// ScheduledFor is when the node is next scheduled to be drained.
+	ScheduledFor time.Time `json:"scheduledFor,omitempty"`
+	// LastAttempt is when the most recent drain attempt was made.
+	LastAttempt time.Time `json:"lastAttempt,omitempty"`
+	// Attempts is the number of drain attempts made so far.
+	Attempts int `json:"attempts,omitempty"`
+	// LastError is the error returned by the most recent failed drain
+	// attempt, if any.
+	LastError string `json:"lastError,omitempty"`
+	// Completed is true once the node has been successfully drained. It
+	// lets Rehydrate tell a finished node apart from one whose drain was
+	// still in flight when the controller restarted.
+	Completed bool `json:"completed,omitempty"`
+	// PodProgress records the disposition of each pod considered during the
+	// most recent drain attempt, keyed by "namespace/name", so that progress
+	// made before a restart isn't lost from the operator's view even though
+	// the attempt itself must be retried from the top.
+	PodProgress map[string]PodDeleteStatus `json:"podProgress,omitempty"`
+}
+
+// A Cache persists NodeRecords across controller restarts, keyed by node
+// UID.
+type Cache interface {
+	// Get returns the record for the supplied node UID, and whether one
+	// exists.
+	Get(uid types.UID) (NodeRecord, bool)
+
+	// Set persists the supplied record for the supplied node UID.
+	Set(uid types.UID, r NodeRecord) error
+
+	// Delete removes any persisted record for the supplied node UID.
+	Delete(uid types.UID) error
+
+	// List returns every persisted record, keyed by node UID.
+	List() (map[types.UID]NodeRecord, error)
+}
+
+// A ConfigMapCache persists NodeRecords as one ConfigMap per node in a
+// configured namespace, allowing draino to rehydrate NodeProcessed and any
+// in-flight drain schedules after a restart.
+type ConfigMapCache struct {
+	c         kubernetes.Interface
+	namespace string
+
+	mu    sync.RWMutex
+	cache map[types.UID]NodeRecord
+}
+
+// NewConfigMapCache returns a Cache that persists records as ConfigMaps in
+// the supplied namespace.
+func NewConfigMapCache(c kubernetes.Interface, namespace string) *ConfigMapCache {
+	return &ConfigMapCache{c: c, namespace: namespace, cache: make(map[types.UID]NodeRecord)}
+}
+
+// Get returns the record for the supplied node UID, and whether one exists.
+func (cm *ConfigMapCache) Get(uid types.UID) (NodeRecord, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	r, ok := cm.cache[uid]
+	return r, ok
+}
+
+// Set persists the supplied record for the supplied node UID.
+func (cm *ConfigMapCache) Set(uid types.UID, r NodeRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot marshal drain record for node %s: %v", uid, err)
+	}
+
+	name := drainCacheConfigMapPrefix + string(uid)
+	existing, err := cm.c.CoreV1().ConfigMaps(cm.namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cfg := &core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: cm.namespace,
+				Labels:    map[string]string{drainCacheLabelManagedBy: drainCacheLabelValue},
+			},
+			Data: map[string]string{drainCacheDataKey: string(b)},
+		}
+		if _, err := cm.c.CoreV1().ConfigMaps(cm.namespace).Create(cfg); err != nil {
+			return fmt.Errorf("cannot create drain record for node %s: %v", uid, err)
+		}
+		cm.mu.Lock()
+		cm.cache[uid] = r
+		cm.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get drain record for node %s: %v", uid, err)
+	}
+
+	existing.Data = map[string]string{drainCacheDataKey: string(b)}
+	if _, err := cm.c.CoreV1().ConfigMaps(cm.namespace).Update(existing); err != nil {
+		return fmt.Errorf("cannot update drain record for node %s: %v", uid, err)
+	}
+	cm.mu.Lock()
+	cm.cache[uid] = r
+	cm.mu.Unlock()
+	return nil
+}
+
+// Delete removes any persisted record for the supplied node UID.
+func (cm *ConfigMapCache) Delete(uid types.UID) error {
+	name := drainCacheConfigMapPrefix + string(uid)
+	err := cm.c.CoreV1().ConfigMaps(cm.namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("cannot delete drain record for node %s: %v", uid, err)
+	}
+	cm.mu.Lock()
+	delete(cm.cache, uid)
+	cm.mu.Unlock()
+	return nil
+}
+
+// List returns every persisted record, keyed by node UID, re-hydrating this
+// cache's in-memory view from the ConfigMaps found in its namespace.
+func (cm *ConfigMapCache) List() (map[types.UID]NodeRecord, error) {
+	sel := fmt.Sprintf("%s=%s", drainCacheLabelManagedBy, drainCacheLabelValue)
+	cms, err := cm.c.CoreV1().ConfigMaps(cm.namespace).List(metav1.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list drain records: %v", err)
+	}
+
+	records := make(map[types.UID]NodeRecord, len(cms.Items))
+	for _, cfg := range cms.Items {
+		if !strings.HasPrefix(cfg.GetName(), drainCacheConfigMapPrefix) {
+			continue
+		}
+		uid := types.UID(strings.TrimPrefix(cfg.GetName(), drainCacheConfigMapPrefix))
+		var r NodeRecord
+		if err := json.Unmarshal([]byte(cfg.Data[drainCacheDataKey]), &r); err != nil {
+			continue
+		}
+		records[uid] = r
+	}
+	cm.mu.Lock()
+	cm.cache = records
+	cm.mu.Unlock()
+	return records, nil
+}
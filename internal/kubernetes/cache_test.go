@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapCacheSetGetDelete(t *testing.T) {
+	c := NewConfigMapCache(fake.NewSimpleClientset(), "default")
+	uid := types.UID("node-a")
+
+	if _, ok := c.Get(uid); ok {
+		t.Fatal("expected no record before Set")
+	}
+
+	r := NodeRecord{CordonedAt: time.Unix(1, 0), Attempts: 1}
+	if err := c.Set(uid, r); err != nil {
+		t.Fatalf("unexpected error from Set: %v", err)
+	}
+
+	got, ok := c.Get(uid)
+	if !ok {
+		t.Fatal("expected a record after Set")
+	}
+	if !got.CordonedAt.Equal(r.CordonedAt) || got.Attempts != r.Attempts {
+		t.Errorf("Get = %+v, want %+v", got, r)
+	}
+
+	r.Completed = true
+	if err := c.Set(uid, r); err != nil {
+		t.Fatalf("unexpected error updating existing record: %v", err)
+	}
+	got, _ = c.Get(uid)
+	if !got.Completed {
+		t.Error("expected updated record to be Completed")
+	}
+
+	if err := c.Delete(uid); err != nil {
+		t.Fatalf("unexpected error from Delete: %v", err)
+	}
+	if _, ok := c.Get(uid); ok {
+		t.Error("expected no record after Delete")
+	}
+}
+
+func TestConfigMapCacheList(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := NewConfigMapCache(client, "default")
+
+	want := map[types.UID]NodeRecord{
+		"node-a": {Attempts: 1},
+		"node-b": {Attempts: 2},
+	}
+	for uid, r := range want {
+		if err := c.Set(uid, r); err != nil {
+			t.Fatalf("unexpected error from Set: %v", err)
+		}
+	}
+
+	// A second cache instance, backed by the same client, must be able to
+	// rehydrate its in-memory view entirely from the ConfigMaps List
+	// discovers - this is what Rehydrate relies on after a restart.
+	fresh := NewConfigMapCache(client, "default")
+	records, err := fresh.List()
+	if err != nil {
+		t.Fatalf("unexpected error from List: %v", err)
+	}
+	if len(records) != len(want) {
+		t.Fatalf("List returned %d records, want %d", len(records), len(want))
+	}
+	for uid, r := range want {
+		got, ok := records[uid]
+		if !ok {
+			t.Errorf("List missing record for %s", uid)
+			continue
+		}
+		if got.Attempts != r.Attempts {
+			t.Errorf("record for %s = %+v, want %+v", uid, got, r)
+		}
+	}
+}
+
+// TestConfigMapCacheConcurrentAccess guards against the data race previously
+// present in ConfigMapCache's in-memory map, which was read and written from
+// multiple goroutines (e.g. concurrent drains in different groups) without
+// synchronization.
+func TestConfigMapCacheConcurrentAccess(t *testing.T) {
+	c := NewConfigMapCache(fake.NewSimpleClientset(), "default")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		uid := types.UID(string(rune('a' + i%26)))
+		wg.Add(2)
+		go func(uid types.UID) {
+			defer wg.Done()
+			_ = c.Set(uid, NodeRecord{Attempts: 1})
+		}(uid)
+		go func(uid types.UID) {
+			defer wg.Done()
+			c.Get(uid)
+		}(uid)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+
+	policy "k8s.io/api/policy/v1beta1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventReasonPodEvictionStarting  = "PodEvictionStarting"
+	eventReasonPodEvictionSucceeded = "PodEvictionSucceeded"
+	eventReasonPodEvictionFailed    = "PodEvictionFailed"
+	eventReasonPodEvictionSkipped   = "PodEvictionSkipped"
+	eventReasonPodEvictionDeferred  = "PodEvictionDeferred"
+)
+
+// A CordonDrainer cordons and drains nodes.
+type CordonDrainer interface {
+	// Cordon the supplied node. Marks it unschedulable for new pods.
+	Cordon(n *core.Node) error
+
+	// Drain the supplied node. Evicts or deletes all pods that are eligible
+	// to be evicted or deleted, per the drainer's configured PodFilters.
+	Drain(n *core.Node) error
+}
+
+// APICordonDrainer drains Kubernetes nodes by deleting or evicting pods via
+// the Kubernetes API.
+type APICordonDrainer struct {
+	c kubernetes.Interface
+	e record.EventRecorder
+
+	filters []PodFilter
+
+	progressMu sync.Mutex
+	progress   map[string]PodDeleteStatus
+}
+
+// APICordonDrainerOption configures an APICordonDrainer.
+type APICordonDrainerOption func(d *APICordonDrainer)
+
+// WithPodFilters configures an APICordonDrainer to evaluate the supplied
+// filters, in order, against each pod on a node being drained. Evaluation of
+// a pod stops at the first filter that skips or errors on it: a skip leaves
+// the pod in place, and an error aborts the drain.
+func WithPodFilters(filters ...PodFilter) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.filters = append(d.filters, filters...)
+	}
+}
+
+// WithEventRecorder configures an APICordonDrainer to emit events against
+// each pod it evicts, skips, or fails to evict, in addition to the events
+// the caller may already be recording against the node itself.
+func WithEventRecorder(e record.EventRecorder) APICordonDrainerOption {
+	return func(d *APICordonDrainer) {
+		d.e = e
+	}
+}
+
+// NewAPICordonDrainer returns a CordonDrainer that cordons and drains nodes
+// via the Kubernetes API.
+func NewAPICordonDrainer(c kubernetes.Interface, ao ...APICordonDrainerOption) *APICordonDrainer {
+	d := &APICordonDrainer{c: c}
+	for _, o := range ao {
+		o(d)
+	}
+	return d
+}
+
+// event records an event against the supplied pod, if this drainer has been
+// configured with an EventRecorder.
+func (d *APICordonDrainer) event(p core.Pod, eventtype, reason, message string) {
+	if d.e == nil {
+		return
+	}
+	pr := &core.ObjectReference{Kind: "Pod", Namespace: p.GetNamespace(), Name: p.GetName(), UID: types.UID(p.GetUID())}
+	d.e.Event(pr, eventtype, reason, message)
+}
+
+// Cordon the supplied node. Marks it unschedulable for new pods.
+func (d *APICordonDrainer) Cordon(n *core.Node) error {
+	fresh, err := d.c.CoreV1().Nodes().Get(n.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get node %s: %v", n.GetName(), err)
+	}
+	if fresh.Spec.Unschedulable {
+		return nil
+	}
+	fresh.Spec.Unschedulable = true
+	if _, err := d.c.CoreV1().Nodes().Update(fresh); err != nil {
+		return fmt.Errorf("cannot cordon node %s: %v", n.GetName(), err)
+	}
+	return nil
+}
+
+// Drain the supplied node. Evicts or deletes every pod on the node that
+// passes the drainer's PodFilters, deferring to each pod's
+// PodDisruptionBudget rather than forcing an eviction that would violate it.
+// If any pod was deferred this way the node isn't fully drained, so Drain
+// returns an error to signal the caller should retry later.
+func (d *APICordonDrainer) Drain(n *core.Node) error {
+	pods, err := d.c.CoreV1().Pods(core.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + n.GetName(),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list pods for node %s: %v", n.GetName(), err)
+	}
+
+	d.resetProgress()
+
+	var deferred []string
+	for _, p := range pods.Items {
+		status, err := d.runFilters(p)
+		if err != nil {
+			return fmt.Errorf("cannot drain pod %s/%s: %v", p.GetNamespace(), p.GetName(), err)
+		}
+		if !status.Delete {
+			d.event(p, core.EventTypeNormal, eventReasonPodEvictionSkipped, status.Message)
+			d.recordProgress(p, status)
+			continue
+		}
+
+		allowed, reason, err := d.disruptionAllowed(p)
+		if err != nil {
+			return fmt.Errorf("cannot evaluate PodDisruptionBudgets for pod %s/%s: %v", p.GetNamespace(), p.GetName(), err)
+		}
+		if !allowed {
+			status = PodDeleteSkip(eventReasonPodEvictionDeferred, reason)
+			d.event(p, core.EventTypeNormal, eventReasonPodEvictionDeferred, reason)
+			d.recordProgress(p, status)
+			deferred = append(deferred, p.GetNamespace()+"/"+p.GetName())
+			continue
+		}
+
+		d.event(p, core.EventTypeWarning, eventReasonPodEvictionStarting, "Evicting pod")
+		if err := d.evict(p); err != nil {
+			d.event(p, core.EventTypeWarning, eventReasonPodEvictionFailed, fmt.Sprintf("Eviction failed: %v", err))
+			d.recordProgress(p, PodDeleteSkip(eventReasonPodEvictionFailed, err.Error()))
+			return fmt.Errorf("cannot evict pod %s/%s: %v", p.GetNamespace(), p.GetName(), err)
+		}
+		d.event(p, core.EventTypeWarning, eventReasonPodEvictionSucceeded, "Evicted pod")
+		d.recordProgress(p, PodDeleteOkay("Evicted"))
+	}
+
+	if len(deferred) > 0 {
+		return fmt.Errorf("node %s not fully drained: %d pod(s) deferred pending PodDisruptionBudget: %v", n.GetName(), len(deferred), deferred)
+	}
+	return nil
+}
+
+// resetProgress clears any progress recorded by a previous Drain call.
+func (d *APICordonDrainer) resetProgress() {
+	d.progressMu.Lock()
+	d.progress = make(map[string]PodDeleteStatus)
+	d.progressMu.Unlock()
+}
+
+// recordProgress records the final disposition of p for this Drain call.
+func (d *APICordonDrainer) recordProgress(p core.Pod, status PodDeleteStatus) {
+	d.progressMu.Lock()
+	d.progress[p.GetNamespace()+"/"+p.GetName()] = status
+	d.progressMu.Unlock()
+}
+
+// DrainProgress returns the disposition of every pod considered during this
+// drainer's most recent Drain call, keyed by "namespace/name". It lets a
+// caller persist per-pod eviction progress even when a drain attempt as a
+// whole fails partway through.
+func (d *APICordonDrainer) DrainProgress() map[string]PodDeleteStatus {
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	progress := make(map[string]PodDeleteStatus, len(d.progress))
+	for k, v := range d.progress {
+		progress[k] = v
+	}
+	return progress
+}
+
+// disruptionAllowed returns false, with an explanatory reason, if evicting p
+// would violate one of its PodDisruptionBudgets (i.e. the PDB currently has
+// no disruptions allowed). Such a pod is deferred rather than evicted; the
+// caller is expected to retry the node's drain later once the PDB's
+// controller has made progress elsewhere.
+func (d *APICordonDrainer) disruptionAllowed(p core.Pod) (bool, string, error) {
+	pdbs, err := d.c.PolicyV1beta1().PodDisruptionBudgets(p.GetNamespace()).List(metav1.ListOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	for _, pdb := range pdbs.Items {
+		sel, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !sel.Matches(labels.Set(p.GetLabels())) {
+			continue
+		}
+		if pdb.Status.DisruptionsAllowed < 1 {
+			return false, fmt.Sprintf("Eviction would violate PodDisruptionBudget %q", pdb.GetName()), nil
+		}
+	}
+	return true, "", nil
+}
+
+// runFilters evaluates every configured PodFilter against p, stopping at the
+// first one that skips or errors it.
+func (d *APICordonDrainer) runFilters(p core.Pod) (PodDeleteStatus, error) {
+	for _, filter := range d.filters {
+		status, err := filter(p)
+		if err != nil {
+			return status, err
+		}
+		if !status.Delete {
+			return status, nil
+		}
+	}
+	return PodDeleteOkay(""), nil
+}
+
+// evict evicts the supplied pod via the Eviction subresource. Callers should
+// check disruptionAllowed first; evict itself does not consult the pod's
+// PodDisruptionBudget.
+func (d *APICordonDrainer) evict(p core.Pod) error {
+	gracePeriod := int64(0)
+	if p.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *p.Spec.TerminationGracePeriodSeconds
+	}
+	eviction := &policy.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.GetNamespace(), Name: p.GetName()},
+		DeleteOptions: &metav1.DeleteOptions{
+			GracePeriodSeconds: &gracePeriod,
+		},
+	}
+	return d.c.PolicyV1beta1().Evictions(p.GetNamespace()).Evict(eviction)
+}
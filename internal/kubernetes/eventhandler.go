@@ -25,6 +25,7 @@ import (
 	"go.uber.org/zap"
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 )
 
@@ -40,6 +41,11 @@ const (
 	eventReasonDrainStarting  = "DrainStarting"
 	eventReasonDrainSucceeded = "DrainSucceeded"
 	eventReasonDrainFailed    = "DrainFailed"
+	eventReasonDrainQueued    = "DrainQueued"
+
+	eventReasonPostDrainActionStarting  = "PostDrainActionStarted"
+	eventReasonPostDrainActionSucceeded = "PostDrainActionSucceeded"
+	eventReasonPostDrainActionFailed    = "PostDrainActionFailed"
 
 	tagResultSucceeded = "succeeded"
 	tagResultFailed    = "failed"
@@ -62,6 +68,20 @@ type DrainingResourceEventHandler struct {
 
 	lastDrainScheduledFor time.Time
 	buffer                time.Duration
+
+	cache       Cache
+	maxAttempts int
+	backoff     wait.Backoff
+
+	scheduler   *DrainScheduler
+	groupBy     Grouper
+	maxPerGroup int
+	maxGlobal   int
+
+	postDrain NodeAction
+
+	leaderElection *leaderElectionConfig
+	isLeader       int32
 }
 
 // DrainingResourceEventHandlerOption configures an DrainingResourceEventHandler.
@@ -82,6 +102,60 @@ func WithDrainBuffer(d time.Duration) DrainingResourceEventHandlerOption {
 	}
 }
 
+// WithDrainStateCache configures a DrainingResourceEventHandler to persist
+// each node's drain state via the supplied Cache, so that a restart of the
+// controller doesn't lose track of nodes it has already cordoned or
+// scheduled for drain.
+func WithDrainStateCache(c Cache) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.cache = c
+	}
+}
+
+// WithDrainRetry configures a DrainingResourceEventHandler to make at most
+// maxAttempts attempts to drain a node, waiting according to backoff between
+// failed attempts. If a Cache is configured, each attempt's count, time and
+// error are persisted to it; a drain re-armed by Rehydrate after a restart
+// counts its attempts from one again.
+func WithDrainRetry(maxAttempts int, backoff wait.Backoff) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.maxAttempts = maxAttempts
+		h.backoff = backoff
+	}
+}
+
+// WithMaxConcurrentDrainsPerGroup configures a DrainingResourceEventHandler
+// to limit how many drains may run concurrently within any group produced
+// by groupBy, and to queue rather than drop nodes beyond that limit. This is
+// useful to avoid e.g. draining every node in an availability zone at once
+// should they all become NotReady simultaneously.
+func WithMaxConcurrentDrainsPerGroup(groupBy Grouper, max int) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.groupBy = groupBy
+		h.maxPerGroup = max
+	}
+}
+
+// WithMaxConcurrentDrains configures a DrainingResourceEventHandler to limit
+// how many drains may run concurrently across the whole cluster, regardless
+// of group, queuing rather than dropping nodes beyond that limit. Combine
+// with WithMaxConcurrentDrainsPerGroup to cap both a group and the cluster
+// as a whole.
+func WithMaxConcurrentDrains(max int) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.maxGlobal = max
+	}
+}
+
+// WithPostDrainAction configures a DrainingResourceEventHandler to run the
+// supplied NodeAction against a node once it has been successfully drained,
+// e.g. to reboot or delete it.
+func WithPostDrainAction(a NodeAction) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.postDrain = a
+	}
+}
+
 // NewDrainingResourceEventHandler returns a new DrainingResourceEventHandler.
 func NewDrainingResourceEventHandler(d CordonDrainer, e record.EventRecorder, ho ...DrainingResourceEventHandlerOption) *DrainingResourceEventHandler {
 	h := &DrainingResourceEventHandler{
@@ -90,19 +164,70 @@ func NewDrainingResourceEventHandler(d CordonDrainer, e record.EventRecorder, ho
 		e:                     e,
 		lastDrainScheduledFor: time.Now(),
 		buffer:                DefaultDrainBuffer,
+		maxAttempts:           1,
 	}
 	for _, o := range ho {
 		o(h)
 	}
+	if h.groupBy != nil || h.maxGlobal > 0 {
+		groupBy := h.groupBy
+		if groupBy == nil {
+			groupBy = func(_ *core.Node) string { return groupGlobal }
+		}
+		h.scheduler = NewDrainScheduler(groupBy, h.maxPerGroup, h.maxGlobal)
+	}
 	return h
 }
 
-// OnAdd cordons and drains the added node.
+// Rehydrate restores state from this handler's Cache, if one is configured.
+// Every node with a persisted record is marked as processed in the supplied
+// NodeProcessed, so that a NodeProcessed-filtered informer doesn't re-cordon
+// a node this controller has already seen before a restart. Any node whose
+// drain hadn't yet completed has its timer re-armed, so in-flight schedules
+// also survive the restart. It should be called once on startup, after the
+// handler has been wired up to the informer that will eventually call
+// OnAdd/OnUpdate for those same nodes, and before that informer starts
+// delivering events.
+func (h *DrainingResourceEventHandler) Rehydrate(nodes map[types.UID]*core.Node, processed NodeProcessed) error {
+	if h.cache == nil {
+		return nil
+	}
+	records, err := h.cache.List()
+	if err != nil {
+		return err
+	}
+	for uid, r := range records {
+		r := r
+		processed[uid] = true
+
+		n, ok := nodes[uid]
+		if !ok || r.Completed || r.ScheduledFor.IsZero() {
+			continue
+		}
+		d := time.Until(r.ScheduledFor)
+		if d < 0 {
+			d = 0
+		}
+		time.AfterFunc(d, func() {
+			if !h.leading() {
+				return
+			}
+			h.runDrain(n, r)
+		})
+	}
+	return nil
+}
+
+// OnAdd cordons and drains the added node. It's a no-op if this handler is
+// configured for leader election and this replica is not the leader.
 func (h *DrainingResourceEventHandler) OnAdd(obj interface{}) {
 	n, ok := obj.(*core.Node)
 	if !ok {
 		return
 	}
+	if !h.leading() {
+		return
+	}
 	h.cordonAndDrain(n)
 }
 
@@ -145,22 +270,113 @@ func (h *DrainingResourceEventHandler) cordonAndDrain(n *core.Node) {
 	d := h.lastDrainScheduledFor.Sub(t) + h.buffer
 	h.lastDrainScheduledFor = t.Add(d)
 
+	r := NodeRecord{CordonedAt: t, ScheduledFor: h.lastDrainScheduledFor}
+	h.persist(n, r)
+
 	log.Info("Scheduled drain", zap.Time("after", h.lastDrainScheduledFor))
 	h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainScheduled, "Will drain node after %s", h.lastDrainScheduledFor.Format(time.RFC3339Nano))
 	time.AfterFunc(d, func() {
 		h.lastDrainScheduledFor = time.Now()
-		log.Debug("Draining")
+		h.runDrain(n, r)
+	})
+}
+
+// runDrain executes the drain for n, routing it through h.scheduler if one
+// is configured so that per-group and global concurrency caps are honoured.
+func (h *DrainingResourceEventHandler) runDrain(n *core.Node, r NodeRecord) {
+	if h.scheduler == nil {
+		h.drain(n, r)
+		return
+	}
+
+	nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+	queued := h.scheduler.Run(n, func(done func()) {
+		defer done()
+		h.drain(n, r)
+	})
+	if queued {
+		h.e.Eventf(nr, core.EventTypeNormal, eventReasonDrainQueued, "Waiting for %s capacity", h.scheduler.groupBy(n))
+	}
+}
+
+// drain attempts to drain n, making at most h.maxAttempts attempts with
+// h.backoff between them. Progress is persisted to h.cache, if configured,
+// after every attempt, so that Rehydrate can re-arm an unfinished drain after
+// a restart.
+func (h *DrainingResourceEventHandler) drain(n *core.Node, r NodeRecord) {
+	log := h.l.With(zap.String("node", n.GetName()))
+	tags, _ := tag.New(context.Background(), tag.Upsert(TagNodeName, n.GetName())) // nolint:gosec
+	nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+
+	backoff := h.backoff
+	for attempt := 1; ; attempt++ {
+		log.Debug("Draining", zap.Int("attempt", attempt))
 		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainStarting, "Draining node")
-		if err := h.d.Drain(n); err != nil {
-			log.Info("Failed to drain", zap.Error(err))
+
+		r.LastAttempt = time.Now()
+		r.Attempts = attempt
+		err := h.d.Drain(n)
+		if reporter, ok := h.d.(interface{ DrainProgress() map[string]PodDeleteStatus }); ok {
+			r.PodProgress = reporter.DrainProgress()
+		}
+		if err == nil {
+			log.Info("Drained")
+			tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultSucceeded)) // nolint:gosec
+			stats.Record(tags, MeasureNodesDrained.M(1))
+			h.e.Event(nr, core.EventTypeWarning, eventReasonDrainSucceeded, "Drained node")
+			r.LastError = ""
+			r.Completed = true
+			h.persist(n, r)
+			h.runPostDrainAction(n, r.LastAttempt, time.Now())
+			return
+		}
+
+		log.Info("Failed to drain", zap.Error(err), zap.Int("attempt", attempt))
+		r.LastError = err.Error()
+		h.persist(n, r)
+
+		if attempt >= h.maxAttempts {
 			tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultFailed)) // nolint:gosec
 			stats.Record(tags, MeasureNodesDrained.M(1))
 			h.e.Eventf(nr, core.EventTypeWarning, eventReasonDrainFailed, "Draining failed: %v", err)
 			return
 		}
-		log.Info("Drained")
-		tags, _ = tag.New(tags, tag.Upsert(TagResult, tagResultSucceeded)) // nolint:gosec
-		stats.Record(tags, MeasureNodesDrained.M(1))
-		h.e.Event(nr, core.EventTypeWarning, eventReasonDrainSucceeded, "Drained node")
-	})
+
+		time.Sleep(backoff.Step())
+	}
+}
+
+// runPostDrainAction runs h.postDrain against n, if one is configured,
+// recording events about its outcome. drainStartedAt and drainCompletedAt
+// describe the drain attempt that just succeeded, and are passed on to
+// h.postDrain if it implements TimedNodeAction.
+func (h *DrainingResourceEventHandler) runPostDrainAction(n *core.Node, drainStartedAt, drainCompletedAt time.Time) {
+	if h.postDrain == nil {
+		return
+	}
+	log := h.l.With(zap.String("node", n.GetName()))
+	nr := &core.ObjectReference{Kind: "Node", Name: n.GetName(), UID: types.UID(n.GetName())}
+
+	if timed, ok := h.postDrain.(TimedNodeAction); ok {
+		timed.SetDrainWindow(drainStartedAt, drainCompletedAt)
+	}
+
+	h.e.Event(nr, core.EventTypeNormal, eventReasonPostDrainActionStarting, "Running post-drain action")
+	if err := h.postDrain.Execute(n); err != nil {
+		log.Info("Post-drain action failed", zap.Error(err))
+		h.e.Eventf(nr, core.EventTypeWarning, eventReasonPostDrainActionFailed, "Post-drain action failed: %v", err)
+		return
+	}
+	log.Info("Post-drain action succeeded")
+	h.e.Event(nr, core.EventTypeNormal, eventReasonPostDrainActionSucceeded, "Post-drain action succeeded")
+}
+
+// persist records r for n via h.cache, if one is configured.
+func (h *DrainingResourceEventHandler) persist(n *core.Node, r NodeRecord) {
+	if h.cache == nil {
+		return
+	}
+	if err := h.cache.Set(n.GetUID(), r); err != nil {
+		h.l.Info("Failed to persist drain record", zap.String("node", n.GetName()), zap.Error(err))
+	}
 }
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDrainEmitsEventsOnSkippedPod(t *testing.T) {
+	node := &core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	daemonSetPod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "ds-pod",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: boolPtr(true)}},
+		},
+		Spec: core.PodSpec{NodeName: "node-a"},
+	}
+
+	client := fake.NewSimpleClientset(daemonSetPod)
+	recorder := record.NewFakeRecorder(10)
+	d := NewAPICordonDrainer(client, WithPodFilters(SkipDaemonSetPodsFilter), WithEventRecorder(recorder))
+
+	if err := d.Drain(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, eventReasonPodEvictionSkipped) {
+			t.Errorf("expected a %s event, got %q", eventReasonPodEvictionSkipped, e)
+		}
+	default:
+		t.Fatal("expected an event to be recorded against the skipped pod")
+	}
+}
+
+func TestDrainEmitsEventsOnEvictedPod(t *testing.T) {
+	node := &core.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+		Spec:       core.PodSpec{NodeName: "node-a"},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+	recorder := record.NewFakeRecorder(10)
+	d := NewAPICordonDrainer(client, WithEventRecorder(recorder))
+
+	if err := d.Drain(node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var reasons []string
+	draining := true
+	for draining {
+		select {
+		case e := <-recorder.Events:
+			reasons = append(reasons, e)
+		default:
+			draining = false
+		}
+	}
+
+	found := false
+	for _, r := range reasons {
+		if strings.Contains(r, eventReasonPodEvictionStarting) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a %s event among %v", eventReasonPodEvictionStarting, reasons)
+	}
+}
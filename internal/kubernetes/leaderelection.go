@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.uber.org/zap"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	eventReasonLeaderElected = "LeaderElected"
+	eventReasonLeaderLost    = "LeaderLost"
+)
+
+// MeasureIsLeader reports 1 if this process holds the leader election lease,
+// 0 otherwise.
+var MeasureIsLeader = stats.Int64("draino/is_leader", "Whether this replica is the elected leader.", stats.UnitDimensionless)
+
+// leaderElectionConfig captures the parameters needed to run leader
+// election for a DrainingResourceEventHandler.
+type leaderElectionConfig struct {
+	id        string
+	namespace string
+	ttl       time.Duration
+}
+
+// WithLeaderElection configures a DrainingResourceEventHandler to only act
+// on OnAdd/OnUpdate events while it holds a coordination.k8s.io Lease named
+// "draino" in the supplied namespace, identifying itself as id. This allows
+// multiple replicas of draino to run for availability without racing to
+// cordon and drain the same nodes. Call RunLeaderElection to start
+// participating in the election; until it reports this replica as leader,
+// OnAdd/OnUpdate are no-ops.
+func WithLeaderElection(id, namespace string, ttl time.Duration) DrainingResourceEventHandlerOption {
+	return func(h *DrainingResourceEventHandler) {
+		h.leaderElection = &leaderElectionConfig{id: id, namespace: namespace, ttl: ttl}
+	}
+}
+
+// RunLeaderElection runs the leader election loop configured via
+// WithLeaderElection, blocking until ctx is cancelled or until this replica
+// loses the lease after having held it; it does not rejoin the election
+// after losing the lease. Only one
+// DrainingResourceEventHandler across the cluster will be leader, and hence
+// process OnAdd/OnUpdate, at any given time. It is a no-op if
+// WithLeaderElection was not configured. Every leader transition is both
+// logged and recorded as an Event against the underlying Lease.
+func (h *DrainingResourceEventHandler) RunLeaderElection(ctx context.Context, c kubernetes.Interface) error {
+	cfg := h.leaderElection
+	if cfg == nil {
+		return nil
+	}
+
+	// Until the election has run at least once, treat this replica as a
+	// follower so it never races an about-to-start leader.
+	h.setLeader(false)
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		cfg.namespace,
+		"draino",
+		c.CoreV1(),
+		c.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: cfg.id},
+	)
+	if err != nil {
+		return err
+	}
+
+	lr := &core.ObjectReference{Kind: "Lease", Namespace: cfg.namespace, Name: "draino", UID: types.UID("draino")}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: cfg.ttl,
+		RenewDeadline: cfg.ttl / 2,
+		RetryPeriod:   cfg.ttl / 4,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				h.l.Info("Acquired leader election lease", zap.String("id", cfg.id))
+				h.setLeader(true)
+				h.e.Eventf(lr, core.EventTypeNormal, eventReasonLeaderElected, "%s became leader", cfg.id)
+			},
+			OnStoppedLeading: func() {
+				h.l.Info("Lost leader election lease", zap.String("id", cfg.id))
+				h.setLeader(false)
+				h.e.Eventf(lr, core.EventTypeNormal, eventReasonLeaderLost, "%s is no longer leader", cfg.id)
+			},
+		},
+	})
+	return nil
+}
+
+// setLeader records whether this replica currently holds the leader
+// election lease, and reports it via the draino/is_leader gauge.
+func (h *DrainingResourceEventHandler) setLeader(leader bool) {
+	var v int64
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&h.isLeader, int32(v))
+	stats.Record(context.Background(), MeasureIsLeader.M(v))
+}
+
+// leading returns true if this handler either isn't configured for leader
+// election, or is configured and currently holds the lease.
+func (h *DrainingResourceEventHandler) leading() bool {
+	if h.leaderElection == nil {
+		return true
+	}
+	return atomic.LoadInt32(&h.isLeader) == 1
+}
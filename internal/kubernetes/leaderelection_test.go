@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestLeadingWithoutLeaderElectionConfigured(t *testing.T) {
+	h := NewDrainingResourceEventHandler(NewAPICordonDrainer(nil), record.NewFakeRecorder(10))
+	if !h.leading() {
+		t.Error("expected a handler without WithLeaderElection to always consider itself leading")
+	}
+}
+
+func TestLeadingWithLeaderElectionConfigured(t *testing.T) {
+	h := NewDrainingResourceEventHandler(
+		NewAPICordonDrainer(nil),
+		record.NewFakeRecorder(10),
+		WithLeaderElection("id", "default", time.Second),
+	)
+
+	if h.leading() {
+		t.Error("expected a handler configured for leader election to not lead before an election has run")
+	}
+
+	h.setLeader(true)
+	if !h.leading() {
+		t.Error("expected leading() to report true once setLeader(true) has been called")
+	}
+
+	h.setLeader(false)
+	if h.leading() {
+		t.Error("expected leading() to report false once setLeader(false) has been called")
+	}
+}
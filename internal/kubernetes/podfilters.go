@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"errors"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	podSkipReasonDaemonSetPod  = "pod is managed by a DaemonSet"
+	podSkipReasonMirrorPod     = "pod is a mirror pod"
+	podSkipReasonCompleted     = "pod has already completed"
+	podErrorReasonLocalStorage = "pod has local storage"
+	podErrorReasonUnreplicated = "pod is not managed by a controller"
+)
+
+// A PodDeleteStatus is returned by a PodFilter to classify how a pod should
+// be handled during a drain.
+type PodDeleteStatus struct {
+	// Delete is true if the pod should be evicted, false if it should be
+	// skipped.
+	Delete bool
+	// Reason is a short reason for the status. Filters set it to a brief
+	// phrase such as "pod is a mirror pod"; the drainer sets it to the event
+	// reason it recorded for a pod it defers or fails to evict.
+	Reason string
+	// Message is a human readable explanation of the status.
+	Message string
+}
+
+// PodDeleteOkay returns a status indicating a pod is safe to evict.
+func PodDeleteOkay(message string) PodDeleteStatus {
+	return PodDeleteStatus{Delete: true, Message: message}
+}
+
+// PodDeleteSkip returns a status indicating a pod should be left alone.
+func PodDeleteSkip(reason, message string) PodDeleteStatus {
+	return PodDeleteStatus{Delete: false, Reason: reason, Message: message}
+}
+
+// A PodFilter classifies a single pod as safe to delete, safe to skip, or
+// errors out to block the drain entirely unless overridden by the caller.
+type PodFilter func(p core.Pod) (PodDeleteStatus, error)
+
+// SkipDaemonSetPodsFilter skips pods that are managed by a DaemonSet, since
+// evicting them achieves nothing: the DaemonSet controller will immediately
+// reschedule them on the same node once it's uncordoned, and they'll be
+// killed anyway when the node goes away.
+func SkipDaemonSetPodsFilter(p core.Pod) (PodDeleteStatus, error) {
+	cr := metav1.GetControllerOf(&p)
+	if cr != nil && cr.Kind == "DaemonSet" {
+		return PodDeleteSkip(podSkipReasonDaemonSetPod, "Skipping DaemonSet-managed pod"), nil
+	}
+	return PodDeleteOkay(""), nil
+}
+
+// SkipMirrorPodsFilter skips mirror pods, i.e. static pods created from a
+// manifest on the node itself. They're not managed by the API server and
+// can't be evicted.
+func SkipMirrorPodsFilter(p core.Pod) (PodDeleteStatus, error) {
+	if _, ok := p.GetAnnotations()[core.MirrorPodAnnotationKey]; ok {
+		return PodDeleteSkip(podSkipReasonMirrorPod, "Skipping mirror pod"), nil
+	}
+	return PodDeleteOkay(""), nil
+}
+
+// SkipCompletedPodsFilter skips pods that have already run to completion,
+// since there's nothing left to evict.
+func SkipCompletedPodsFilter(p core.Pod) (PodDeleteStatus, error) {
+	if p.Status.Phase == core.PodSucceeded || p.Status.Phase == core.PodFailed {
+		return PodDeleteSkip(podSkipReasonCompleted, "Skipping completed pod"), nil
+	}
+	return PodDeleteOkay(""), nil
+}
+
+// LocalStoragePodsFilter returns a PodFilter that errors on pods using an
+// emptyDir volume, since evicting them loses that data. If deleteEmptyDir is
+// true such pods are instead considered safe to delete.
+func LocalStoragePodsFilter(deleteEmptyDir bool) PodFilter {
+	return func(p core.Pod) (PodDeleteStatus, error) {
+		for _, v := range p.Spec.Volumes {
+			if v.EmptyDir == nil {
+				continue
+			}
+			if deleteEmptyDir {
+				return PodDeleteOkay("Deleting pod with local storage"), nil
+			}
+			return PodDeleteStatus{}, errors.New(podErrorReasonLocalStorage)
+		}
+		return PodDeleteOkay(""), nil
+	}
+}
+
+// UnreplicatedPodsFilter returns a PodFilter that errors on pods that are not
+// managed by a controller (ReplicaSet, StatefulSet, Job, etc), since evicting
+// them means they're gone for good. If force is true such pods are instead
+// considered safe to delete.
+func UnreplicatedPodsFilter(force bool) PodFilter {
+	return func(p core.Pod) (PodDeleteStatus, error) {
+		if metav1.GetControllerOf(&p) != nil {
+			return PodDeleteOkay(""), nil
+		}
+		if force {
+			return PodDeleteOkay("Deleting unreplicated pod"), nil
+		}
+		return PodDeleteStatus{}, errors.New(podErrorReasonUnreplicated)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSkipDaemonSetPodsFilter(t *testing.T) {
+	cases := map[string]struct {
+		pod    core.Pod
+		delete bool
+	}{
+		"DaemonSetPod": {
+			pod:    core.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet", Controller: boolPtr(true)}}}},
+			delete: false,
+		},
+		"ReplicaSetPod": {
+			pod:    core.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Controller: boolPtr(true)}}}},
+			delete: true,
+		},
+		"NoController": {
+			pod:    core.Pod{},
+			delete: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			status, err := SkipDaemonSetPodsFilter(c.pod)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status.Delete != c.delete {
+				t.Errorf("Delete = %v, want %v", status.Delete, c.delete)
+			}
+		})
+	}
+}
+
+func TestSkipMirrorPodsFilter(t *testing.T) {
+	mirror := core.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{core.MirrorPodAnnotationKey: ""}}}
+	status, err := SkipMirrorPodsFilter(mirror)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Delete {
+		t.Error("expected mirror pod to be skipped")
+	}
+
+	ordinary := core.Pod{}
+	status, err = SkipMirrorPodsFilter(ordinary)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Delete {
+		t.Error("expected non-mirror pod to be deletable")
+	}
+}
+
+func TestSkipCompletedPodsFilter(t *testing.T) {
+	cases := map[string]struct {
+		phase  core.PodPhase
+		delete bool
+	}{
+		"Succeeded": {phase: core.PodSucceeded, delete: false},
+		"Failed":    {phase: core.PodFailed, delete: false},
+		"Running":   {phase: core.PodRunning, delete: true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			status, err := SkipCompletedPodsFilter(core.Pod{Status: core.PodStatus{Phase: c.phase}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status.Delete != c.delete {
+				t.Errorf("Delete = %v, want %v", status.Delete, c.delete)
+			}
+		})
+	}
+}
+
+func TestLocalStoragePodsFilter(t *testing.T) {
+	withEmptyDir := core.Pod{Spec: core.PodSpec{Volumes: []core.Volume{{VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}}}}}
+
+	filter := LocalStoragePodsFilter(false)
+	if _, err := filter(withEmptyDir); err == nil {
+		t.Error("expected error for pod with local storage when deleteEmptyDir is false")
+	}
+
+	filter = LocalStoragePodsFilter(true)
+	status, err := filter(withEmptyDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Delete {
+		t.Error("expected pod with local storage to be deletable when deleteEmptyDir is true")
+	}
+
+	status, err = filter(core.Pod{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Delete {
+		t.Error("expected pod without local storage to be deletable")
+	}
+}
+
+func TestUnreplicatedPodsFilter(t *testing.T) {
+	unreplicated := core.Pod{}
+	replicated := core.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Controller: boolPtr(true)}}}}
+
+	filter := UnreplicatedPodsFilter(false)
+	if _, err := filter(unreplicated); err == nil {
+		t.Error("expected error for unreplicated pod when force is false")
+	}
+	if status, err := filter(replicated); err != nil || !status.Delete {
+		t.Errorf("expected replicated pod to be deletable, got status=%+v err=%v", status, err)
+	}
+
+	filter = UnreplicatedPodsFilter(true)
+	status, err := filter(unreplicated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.Delete {
+		t.Error("expected unreplicated pod to be deletable when force is true")
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
@@ -0,0 +1,183 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	core "k8s.io/api/core/v1"
+)
+
+const (
+	// LabelZone is the well-known label used to group nodes by failure
+	// domain.
+	LabelZone = "topology.kubernetes.io/zone"
+	// LabelInstanceType is the well-known label used to group nodes by
+	// instance type.
+	LabelInstanceType = "node.kubernetes.io/instance-type"
+
+	// groupGlobal is the group every node falls into when no Grouper is
+	// configured, so that only the scheduler's global cap applies.
+	groupGlobal = "*"
+)
+
+// Opencensus measurements for the drain scheduler.
+var (
+	MeasureDrainsInFlight = stats.Int64("draino/drains_in_flight", "Number of drains currently in flight.", stats.UnitDimensionless)
+	MeasureDrainsQueued   = stats.Int64("draino/drains_queued", "Number of drains waiting for capacity.", stats.UnitDimensionless)
+
+	TagGroup, _ = tag.NewKey("group")
+)
+
+// A Grouper extracts the failure domain or other grouping key a node belongs
+// to, for the purpose of capping concurrent drains within that group.
+type Grouper func(n *core.Node) string
+
+// GroupByLabel returns a Grouper that groups nodes by the value of the
+// supplied label. Nodes missing the label are grouped together under the
+// empty string.
+func GroupByLabel(key string) Grouper {
+	return func(n *core.Node) string {
+		return n.GetLabels()[key]
+	}
+}
+
+// GroupByZone groups nodes by their topology.kubernetes.io/zone label.
+var GroupByZone = GroupByLabel(LabelZone)
+
+// GroupByInstanceType groups nodes by their node.kubernetes.io/instance-type
+// label.
+var GroupByInstanceType = GroupByLabel(LabelInstanceType)
+
+// A DrainScheduler limits how many drains may run concurrently, both overall
+// and within a group as determined by a Grouper. Nodes that can't be run
+// immediately are queued rather than dropped. Queued drains within a group
+// run in the order they were submitted; across groups, the order in which
+// queued drains start is not defined.
+type DrainScheduler struct {
+	mu sync.Mutex
+
+	groupBy      Grouper
+	maxPerGroup  int
+	maxGlobal    int
+	inFlight     map[string]int
+	globalInFlight int
+	queue        map[string][]func()
+}
+
+// NewDrainScheduler returns a DrainScheduler that allows at most maxPerGroup
+// concurrent drains within any group produced by groupBy, and at most
+// maxGlobal concurrent drains overall. A maxGlobal of 0 means no global cap.
+func NewDrainScheduler(groupBy Grouper, maxPerGroup, maxGlobal int) *DrainScheduler {
+	return &DrainScheduler{
+		groupBy:     groupBy,
+		maxPerGroup: maxPerGroup,
+		maxGlobal:   maxGlobal,
+		inFlight:    make(map[string]int),
+		queue:       make(map[string][]func()),
+	}
+}
+
+// Run submits drain to be run against n, subject to this scheduler's
+// concurrency caps. If capacity is available drain runs immediately (in a
+// new goroutine); otherwise it's queued and queued reports that so the
+// caller can surface it, e.g. as an Event on the node. drain must call
+// done when it has finished, to release its slot and start the next queued
+// drain that capacity allows.
+func (s *DrainScheduler) Run(n *core.Node, drain func(done func())) (queued bool) {
+	group := s.groupBy(n)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasCapacityLocked(group) {
+		s.admitLocked(group)
+		go drain(func() { s.release(group) })
+		return false
+	}
+
+	s.queue[group] = append(s.queue[group], func() {
+		go drain(func() { s.release(group) })
+	})
+	s.recordLocked(group)
+	return true
+}
+
+func (s *DrainScheduler) hasCapacityLocked(group string) bool {
+	if s.maxGlobal > 0 && s.globalInFlight >= s.maxGlobal {
+		return false
+	}
+	if s.maxPerGroup > 0 && s.inFlight[group] >= s.maxPerGroup {
+		return false
+	}
+	return true
+}
+
+func (s *DrainScheduler) admitLocked(group string) {
+	s.inFlight[group]++
+	s.globalInFlight++
+	s.recordLocked(group)
+}
+
+func (s *DrainScheduler) recordLocked(group string) {
+	tags, _ := tag.New(context.Background(), tag.Upsert(TagGroup, group)) // nolint:gosec
+	stats.Record(tags, MeasureDrainsInFlight.M(int64(s.inFlight[group])))
+	stats.Record(tags, MeasureDrainsQueued.M(int64(len(s.queue[group]))))
+}
+
+// release frees the slot held by a finished drain in the supplied group, and
+// starts the next queued drain that capacity now allows. The freed slot may
+// have freed up room in group itself, or - if the global cap rather than
+// any per-group cap was the binding constraint - in any other group with a
+// non-empty queue, so release tries group first (to preserve FIFO order
+// within it) and then falls back to scanning every other group.
+func (s *DrainScheduler) release(group string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight[group]--
+	s.globalInFlight--
+
+	if s.dequeueLocked(group) {
+		return
+	}
+	for g := range s.queue {
+		if g == group {
+			continue
+		}
+		if s.dequeueLocked(g) {
+			return
+		}
+	}
+	s.recordLocked(group)
+}
+
+// dequeueLocked starts the next queued drain in group if one is waiting and
+// capacity allows, reporting whether it did so.
+func (s *DrainScheduler) dequeueLocked(group string) bool {
+	if len(s.queue[group]) == 0 || !s.hasCapacityLocked(group) {
+		return false
+	}
+	next := s.queue[group][0]
+	s.queue[group] = s.queue[group][1:]
+	s.admitLocked(group)
+	next()
+	return true
+}
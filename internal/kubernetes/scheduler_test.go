@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Planet Labs Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+implied. See the License for the specific language governing permissions
+and limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeIn(group string) *core.Node {
+	return &core.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"group": group}}}
+}
+
+func TestDrainSchedulerPerGroupCap(t *testing.T) {
+	s := NewDrainScheduler(GroupByLabel("group"), 1, 0)
+
+	block := make(chan struct{})
+	started := make(chan func())
+	queued := s.Run(nodeIn("a"), func(d func()) { started <- d; <-block })
+	if queued {
+		t.Fatal("expected first drain in a group to run immediately")
+	}
+	done := <-started
+
+	ran := make(chan struct{})
+	queued = s.Run(nodeIn("a"), func(d func()) { close(ran); d() })
+	if !queued {
+		t.Fatal("expected second concurrent drain in the same group to be queued")
+	}
+
+	select {
+	case <-ran:
+		t.Fatal("queued drain ran before capacity freed up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block)
+	done()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("queued drain never ran after capacity freed up")
+	}
+}
+
+func TestDrainSchedulerReleaseDrainsOtherGroupsOnGlobalCapacity(t *testing.T) {
+	// A global cap of 1 with two groups: a drain queued in group "b" solely
+	// because the global cap is full (group "b"'s own per-group cap is
+	// nowhere near exhausted) must be released once the in-flight drain in
+	// group "a" finishes, even though release is called with group "a".
+	s := NewDrainScheduler(GroupByLabel("group"), 0, 1)
+
+	block := make(chan struct{})
+	started := make(chan func())
+	queued := s.Run(nodeIn("a"), func(d func()) { started <- d; <-block })
+	if queued {
+		t.Fatal("expected first drain to run immediately")
+	}
+	done := <-started
+
+	ran := make(chan struct{})
+	queued = s.Run(nodeIn("b"), func(d func()) { close(ran); d() })
+	if !queued {
+		t.Fatal("expected drain in group b to be queued while global capacity is exhausted by group a")
+	}
+
+	close(block)
+	done()
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("group b's queued drain never ran after group a released global capacity")
+	}
+}
+
+func TestDrainSchedulerNoCaps(t *testing.T) {
+	s := NewDrainScheduler(GroupByLabel("group"), 0, 0)
+
+	ran := make(chan struct{})
+	queued := s.Run(nodeIn("a"), func(d func()) { close(ran); d() })
+	if queued {
+		t.Fatal("expected drain to run immediately when no caps are configured")
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("drain never ran")
+	}
+}